@@ -0,0 +1,72 @@
+// Copyright 2020 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package identity
+
+import "strings"
+
+// NewKeycloakExtractor returns an Extractor configured for tokens issued
+// by Keycloak, reading roles from the realm_access.roles claim. A
+// "device" role (case-insensitive) marks the bearer as a device;
+// any other role marks it as a user.
+func NewKeycloakExtractor() *Extractor {
+	return NewExtractor().
+		WithRoleClaim("realm_access.roles", rolesToUserDevice).
+		Build()
+}
+
+// NewAuth0Extractor returns an Extractor configured for tokens issued by
+// Auth0, reading roles from the custom "<namespace>/roles" claim Auth0
+// rules/actions are typically configured to add, since Auth0 does not
+// allow unnamespaced custom claims. namespace is usually a URL such as
+// "https://example.com".
+func NewAuth0Extractor(namespace string) *Extractor {
+	claim := strings.TrimRight(namespace, "/") + "/roles"
+	return NewExtractor().
+		WithRoleClaim(claim, rolesToUserDevice).
+		Build()
+}
+
+// NewOIDCExtractor returns an Extractor for generic OIDC providers that
+// don't set Mender's "sub" convention, falling back to the standard
+// "preferred_username" and "email" claims for Identity.Subject.
+func NewOIDCExtractor() *Extractor {
+	return NewExtractor().
+		WithSubjectClaim(subjectClaim, "preferred_username", "email").
+		Build()
+}
+
+// rolesToUserDevice interprets value as a list of role names (as
+// produced by Keycloak's realm_access.roles or an Auth0 custom roles
+// claim): any role named "device" (case-insensitive) marks the bearer
+// as a device, and the presence of any other role marks it as a user.
+func rolesToUserDevice(value interface{}) (isUser, isDevice bool) {
+	roles, ok := value.([]interface{})
+	if !ok {
+		return false, false
+	}
+
+	for _, r := range roles {
+		name, ok := r.(string)
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(name, "device") {
+			isDevice = true
+		} else {
+			isUser = true
+		}
+	}
+
+	return isUser, isDevice
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package identity
+
+import (
+	"strings"
+	"time"
+)
+
+// Standard claim names consumed in addition to the Mender-specific ones
+// declared in token.go.
+const (
+	scopeClaim         = "scope"
+	scpClaim           = "scp"
+	rolesClaim         = "roles"
+	keycloakRolesClaim = "realm_access.roles"
+	groupsClaim        = "groups"
+	audienceClaim      = "aud"
+	expClaim           = "exp"
+	iatClaim           = "iat"
+)
+
+// populateExtendedClaims fills in the scopes/roles/audience/timestamps/
+// raw-claims part of identity from claims. It is shared by
+// identityFromClaims and Extractor.MapIdentity so both agree on how
+// these claims are read.
+func populateExtendedClaims(identity *Identity, claims rawClaims) {
+	identity.Scopes = getScopeClaim(claims)
+	identity.Roles = getRolesClaim(claims)
+	identity.Groups = stringSliceClaim(claims, groupsClaim)
+	identity.Audience = getAudienceClaim(claims)
+	identity.ExpiresAt = getTimeClaim(claims, expClaim)
+	identity.IssuedAt = getTimeClaim(claims, iatClaim)
+	identity.Raw = map[string]interface{}(claims)
+}
+
+// getRolesClaim reads the top-level "roles" claim or, failing that,
+// Keycloak's nested "realm_access.roles" claim. An Extractor configured
+// with WithRoleClaim may still override the result with its own claim.
+func getRolesClaim(claims rawClaims) []string {
+	if roles := stringSliceClaim(claims, rolesClaim); len(roles) > 0 {
+		return roles
+	}
+
+	if val, ok := getNestedClaim(claims, keycloakRolesClaim); ok {
+		if roles, ok := val.([]interface{}); ok {
+			return stringSlice(roles)
+		}
+	}
+
+	return nil
+}
+
+// getScopeClaim reads the OAuth2 "scope" claim (a space-delimited
+// string) or, failing that, the "scp" claim used by some providers (an
+// array of strings).
+func getScopeClaim(claims rawClaims) []string {
+	if raw, ok := claims[scopeClaim]; ok {
+		if s, ok := raw.(string); ok && s != "" {
+			return strings.Fields(s)
+		}
+	}
+	return stringSliceClaim(claims, scpClaim)
+}
+
+// getAudienceClaim reads the "aud" claim, which per RFC 7519 may be
+// either a single string or an array of strings.
+func getAudienceClaim(claims rawClaims) []string {
+	raw, ok := claims[audienceClaim]
+	if !ok {
+		return nil
+	}
+
+	switch v := raw.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []interface{}:
+		return stringSlice(v)
+	default:
+		return nil
+	}
+}
+
+// getTimeClaim reads a NumericDate claim (seconds since the Unix epoch,
+// per RFC 7519 section 2) and returns the zero time.Time if it is
+// missing or not a number.
+func getTimeClaim(claims rawClaims, name string) time.Time {
+	raw, ok := claims[name]
+	if !ok {
+		return time.Time{}
+	}
+
+	seconds, ok := raw.(float64)
+	if !ok {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(seconds), 0).UTC()
+}
+
+// stringSliceClaim reads claim name as an array of strings.
+func stringSliceClaim(claims rawClaims, name string) []string {
+	raw, ok := claims[name]
+	if !ok {
+		return nil
+	}
+
+	values, ok := raw.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	return stringSlice(values)
+}
+
+// stringSlice converts a []interface{} of strings (as produced by
+// encoding/json) to a []string, skipping any non-string elements.
+func stringSlice(values []interface{}) []string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+
+	return out
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package identity
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractIdentityExtendedClaims(t *testing.T) {
+	enc := base64.URLEncoding.EncodeToString([]byte(`{
+		"sub": "123",
+		"scope": "read write",
+		"roles": ["admin", "support"],
+		"aud": ["api", "web"],
+		"exp": 1700000100,
+		"iat": 1700000000
+	}`))
+
+	idata, err := ExtractIdentity("foo." + enc + ".bar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"read", "write"}, idata.Scopes)
+	assert.Equal(t, []string{"admin", "support"}, idata.Roles)
+	assert.Equal(t, []string{"api", "web"}, idata.Audience)
+	assert.Equal(t, time.Unix(1700000100, 0).UTC(), idata.ExpiresAt)
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), idata.IssuedAt)
+
+	assert.True(t, idata.HasScope("read"))
+	assert.False(t, idata.HasScope("admin"))
+	assert.True(t, idata.HasRole("support"))
+	assert.False(t, idata.HasRole("read"))
+}
+
+func TestExtractIdentityScopeFallsBackToScp(t *testing.T) {
+	enc := base64.URLEncoding.EncodeToString([]byte(`{"sub": "123", "scp": ["read", "write"]}`))
+
+	idata, err := ExtractIdentity("foo." + enc + ".bar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"read", "write"}, idata.Scopes)
+}
+
+func TestExtractIdentitySingleAudience(t *testing.T) {
+	enc := base64.URLEncoding.EncodeToString([]byte(`{"sub": "123", "aud": "api"}`))
+
+	idata, err := ExtractIdentity("foo." + enc + ".bar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"api"}, idata.Audience)
+}
+
+func TestExtractIdentityRolesFallsBackToKeycloakRealmAccess(t *testing.T) {
+	enc := base64.URLEncoding.EncodeToString(
+		[]byte(`{"sub": "123", "realm_access": {"roles": ["admin", "support"]}}`))
+
+	idata, err := ExtractIdentity("foo." + enc + ".bar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "support"}, idata.Roles)
+}
+
+func TestExtractIdentityRolesPrefersTopLevelClaim(t *testing.T) {
+	enc := base64.URLEncoding.EncodeToString([]byte(
+		`{"sub": "123", "roles": ["support"], "realm_access": {"roles": ["admin"]}}`))
+
+	idata, err := ExtractIdentity("foo." + enc + ".bar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"support"}, idata.Roles)
+}
+
+func TestExtractIdentityGroups(t *testing.T) {
+	enc := base64.URLEncoding.EncodeToString([]byte(`{"sub": "123", "groups": ["ops", "eng"]}`))
+
+	idata, err := ExtractIdentity("foo." + enc + ".bar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ops", "eng"}, idata.Groups)
+}
+
+func TestIdentityHasScopeHasRoleZeroValue(t *testing.T) {
+	var idata Identity
+	assert.False(t, idata.HasScope("anything"))
+	assert.False(t, idata.HasRole("anything"))
+}
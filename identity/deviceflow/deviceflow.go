@@ -0,0 +1,285 @@
+// Copyright 2020 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package deviceflow implements the OAuth 2.0 Device Authorization Grant
+// (RFC 8628), allowing device-side tools that consume this library to
+// obtain a Mender-compatible JWT without a browser available locally.
+package deviceflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+)
+
+// Error codes returned by the token endpoint, as defined by RFC 8628
+// section 3.5.
+const (
+	ErrorAuthorizationPending = "authorization_pending"
+	ErrorSlowDown             = "slow_down"
+	ErrorAccessDenied         = "access_denied"
+	ErrorExpiredToken         = "expired_token"
+)
+
+// slowDownIncrement is the amount by which the polling interval is
+// increased every time the server responds with "slow_down".
+const slowDownIncrement = 5 * time.Second
+
+// DeviceCodeResponse is the response to a device authorization request,
+// as defined by RFC 8628 section 3.2.
+type DeviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// TokenResponse is a successful response from the token endpoint, as
+// defined by RFC 8628 section 3.5, enriched with the Identity decoded
+// from the access token.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+
+	Identity identity.Identity `json:"-"`
+}
+
+type tokenErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// AccessDeniedError is returned by Poll when the user (or an
+// administrator) denied the authorization request.
+type AccessDeniedError struct {
+	Description string
+}
+
+func (e *AccessDeniedError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("deviceflow: access denied: %s", e.Description)
+	}
+	return "deviceflow: access denied"
+}
+
+// ExpiredTokenError is returned by Poll when the device_code has expired
+// before the user completed the authorization.
+type ExpiredTokenError struct {
+	Description string
+}
+
+func (e *ExpiredTokenError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("deviceflow: device code expired: %s", e.Description)
+	}
+	return "deviceflow: device code expired"
+}
+
+// Client drives the device authorization grant against a single
+// authorization server.
+type Client struct {
+	// DeviceAuthorizationEndpoint is the URL that issues device codes.
+	DeviceAuthorizationEndpoint string
+	// TokenEndpoint is the URL that exchanges a device code for a token.
+	TokenEndpoint string
+	// ClientID identifies the requesting client, as registered with the
+	// authorization server.
+	ClientID string
+	// Scope is an optional space-delimited list of scopes to request.
+	Scope string
+
+	// HTTPClient is used to make requests; http.DefaultClient is used
+	// when nil.
+	HTTPClient *http.Client
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// RequestCode requests a new device code from the authorization server.
+func (c *Client) RequestCode(ctx context.Context) (*DeviceCodeResponse, error) {
+	form := url.Values{"client_id": {c.ClientID}}
+	if c.Scope != "" {
+		form.Set("scope", c.Scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.DeviceAuthorizationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	rsp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "deviceflow: device authorization request failed")
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("deviceflow: unexpected status %v from device authorization endpoint", rsp.StatusCode)
+	}
+
+	var code DeviceCodeResponse
+	if err := json.NewDecoder(rsp.Body).Decode(&code); err != nil {
+		return nil, errors.Wrap(err, "deviceflow: failed to decode device code response")
+	}
+
+	return &code, nil
+}
+
+// Poll repeatedly exchanges deviceCode for a token at the interval
+// requested by the authorization server, until it succeeds or returns a
+// terminal error (*AccessDeniedError or *ExpiredTokenError). The
+// returned token's access_token is decoded into TokenResponse.Identity
+// using identity.ExtractIdentity.
+func (c *Client) Poll(ctx context.Context, code *DeviceCodeResponse) (*TokenResponse, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		tok, slowDown, err := c.pollOnce(ctx, code.DeviceCode)
+		if err != nil {
+			return nil, err
+		}
+		if slowDown {
+			interval += slowDownIncrement
+			continue
+		}
+		if tok != nil {
+			return tok, nil
+		}
+	}
+}
+
+// pollOnce performs a single token request, returning (token, false,
+// nil) on success, (nil, true, nil) when the server asked to slow down,
+// (nil, false, nil) while authorization is still pending, and a non-nil
+// error for any terminal failure.
+func (c *Client) pollOnce(ctx context.Context, deviceCode string) (*TokenResponse, bool, error) {
+	form := url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {deviceCode},
+		"client_id":   {c.ClientID},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	rsp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "deviceflow: token request failed")
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode == http.StatusOK {
+		var tok TokenResponse
+		if err := json.NewDecoder(rsp.Body).Decode(&tok); err != nil {
+			return nil, false, errors.Wrap(err, "deviceflow: failed to decode token response")
+		}
+
+		idata, err := identity.ExtractIdentity(tok.AccessToken)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "deviceflow: failed to extract identity from access token")
+		}
+		tok.Identity = idata
+
+		return &tok, false, nil
+	}
+
+	var tokErr tokenErrorResponse
+	if err := json.NewDecoder(rsp.Body).Decode(&tokErr); err != nil {
+		return nil, false, errors.Wrapf(err, "deviceflow: unexpected status %v from token endpoint", rsp.StatusCode)
+	}
+
+	switch tokErr.Error {
+	case ErrorAuthorizationPending:
+		return nil, false, nil
+	case ErrorSlowDown:
+		return nil, true, nil
+	case ErrorAccessDenied:
+		return nil, false, &AccessDeniedError{Description: tokErr.ErrorDescription}
+	case ErrorExpiredToken:
+		return nil, false, &ExpiredTokenError{Description: tokErr.ErrorDescription}
+	default:
+		return nil, false, errors.Errorf("deviceflow: token endpoint returned error %q: %v", tokErr.Error, tokErr.ErrorDescription)
+	}
+}
+
+// WriteUserCode renders the verification_uri and user_code to w, in the
+// format a CLI would print for the user to visit in a browser. When
+// verification_uri_complete is present, only that URL is written and the
+// user_code is not.
+func WriteUserCode(w io.Writer, code *DeviceCodeResponse) error {
+	if code.VerificationURIComplete != "" {
+		_, err := fmt.Fprintf(w, "To authorize this device, visit:\n\n    %s\n\n",
+			code.VerificationURIComplete)
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "To authorize this device, visit:\n\n    %s\n\nand enter code: %s\n\n",
+		code.VerificationURI, code.UserCode)
+	return err
+}
+
+// WriteQRCode renders a QR code for verification_uri_complete to w,
+// encoded as PNG, using encoder to produce the image bytes from the URL
+// string. Callers supply encoder so this package does not depend on a
+// particular QR code library; a typical implementation wraps
+// github.com/skip2/go-qrcode.
+func WriteQRCode(w io.Writer, code *DeviceCodeResponse, encoder func(string) ([]byte, error)) error {
+	if code.VerificationURIComplete == "" {
+		return errors.New("deviceflow: verification_uri_complete not provided by server")
+	}
+
+	png, err := encoder(code.VerificationURIComplete)
+	if err != nil {
+		return errors.Wrap(err, "deviceflow: failed to render QR code")
+	}
+
+	_, err = w.Write(png)
+	return err
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package deviceflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeAccessToken(sub string) string {
+	header := base64.URLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	claims := base64.URLEncoding.EncodeToString([]byte(`{"sub":"` + sub + `"}`))
+	return header + "." + claims + ".sig"
+}
+
+func TestRequestCode(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "my-client", r.Form.Get("client_id"))
+
+		_ = json.NewEncoder(w).Encode(DeviceCodeResponse{
+			DeviceCode:      "devcode",
+			UserCode:        "ABCD-EFGH",
+			VerificationURI: "https://example.com/activate",
+			ExpiresIn:       600,
+			Interval:        0,
+		})
+	}))
+	defer srv.Close()
+
+	c := &Client{DeviceAuthorizationEndpoint: srv.URL, ClientID: "my-client"}
+	code, err := c.RequestCode(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "devcode", code.DeviceCode)
+	assert.Equal(t, "ABCD-EFGH", code.UserCode)
+}
+
+func TestPollSuccess(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(tokenErrorResponse{Error: ErrorAuthorizationPending})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(TokenResponse{AccessToken: makeAccessToken("device-1")})
+	}))
+	defer srv.Close()
+
+	c := &Client{TokenEndpoint: srv.URL, ClientID: "my-client"}
+	tok, err := c.Poll(context.Background(), &DeviceCodeResponse{DeviceCode: "devcode", Interval: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "device-1", tok.Identity.Subject)
+}
+
+func TestPollAccessDenied(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(tokenErrorResponse{Error: ErrorAccessDenied})
+	}))
+	defer srv.Close()
+
+	c := &Client{TokenEndpoint: srv.URL, ClientID: "my-client"}
+	_, err := c.Poll(context.Background(), &DeviceCodeResponse{DeviceCode: "devcode", Interval: 1})
+	require.Error(t, err)
+	_, ok := err.(*AccessDeniedError)
+	assert.True(t, ok)
+}
+
+func TestPollExpiredToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(tokenErrorResponse{Error: ErrorExpiredToken})
+	}))
+	defer srv.Close()
+
+	c := &Client{TokenEndpoint: srv.URL, ClientID: "my-client"}
+	_, err := c.Poll(context.Background(), &DeviceCodeResponse{DeviceCode: "devcode", Interval: 1})
+	require.Error(t, err)
+	_, ok := err.(*ExpiredTokenError)
+	assert.True(t, ok)
+}
+
+func TestWriteUserCode(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteUserCode(&buf, &DeviceCodeResponse{
+		UserCode:        "ABCD-EFGH",
+		VerificationURI: "https://example.com/activate",
+	})
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "ABCD-EFGH")
+	assert.Contains(t, buf.String(), "https://example.com/activate")
+}
+
+func TestWriteQRCode(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteQRCode(&buf, &DeviceCodeResponse{}, func(s string) ([]byte, error) {
+		return []byte("png"), nil
+	})
+	assert.Error(t, err)
+
+	err = WriteQRCode(&buf, &DeviceCodeResponse{VerificationURIComplete: "https://example.com/activate?code=1"},
+		func(s string) ([]byte, error) {
+			return []byte("png"), nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, "png", buf.String())
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package identity
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RoleClaimFunc inspects the raw value of a configured role claim and
+// decides whether it marks the token's bearer as a user, a device, or
+// neither.
+type RoleClaimFunc func(value interface{}) (isUser, isDevice bool)
+
+// ClaimMapper maps a decoded set of JWT claims onto an Identity. It is
+// the extension point behind Extractor, allowing callers to plug in
+// claim-mapping logic for tokens that don't follow the Mender
+// conventions (mender.tenant, mender.user, ...).
+type ClaimMapper interface {
+	MapIdentity(claims map[string]interface{}) (Identity, error)
+}
+
+// ExtractorBuilder builds an Extractor. Obtain one with NewExtractor.
+type ExtractorBuilder struct {
+	subjectClaims []string
+	tenantClaims  []string
+	planClaims    []string
+
+	roleClaim string
+	roleFunc  RoleClaimFunc
+}
+
+// NewExtractor returns a builder seeded with the same claim names
+// ExtractIdentity uses, so that Build() with no further calls reproduces
+// today's behavior exactly.
+func NewExtractor() *ExtractorBuilder {
+	return &ExtractorBuilder{
+		subjectClaims: []string{subjectClaim},
+		tenantClaims:  []string{tenantClaim},
+		planClaims:    []string{planClaim},
+	}
+}
+
+// WithSubjectClaim sets the claim name(s) used to populate
+// Identity.Subject. When multiple names are given, they are tried in
+// order and the first one present in the token wins.
+func (b *ExtractorBuilder) WithSubjectClaim(names ...string) *ExtractorBuilder {
+	b.subjectClaims = names
+	return b
+}
+
+// WithTenantClaim sets the claim name(s) used to populate
+// Identity.Tenant, tried in order like WithSubjectClaim.
+func (b *ExtractorBuilder) WithTenantClaim(names ...string) *ExtractorBuilder {
+	b.tenantClaims = names
+	return b
+}
+
+// WithPlanClaim sets the claim name(s) used to populate Identity.Plan,
+// tried in order like WithSubjectClaim.
+func (b *ExtractorBuilder) WithPlanClaim(names ...string) *ExtractorBuilder {
+	b.planClaims = names
+	return b
+}
+
+// WithRoleClaim configures a single claim (dotted paths such as
+// "realm_access.roles" are resolved through nested objects) whose raw
+// value is passed to fn to decide Identity.IsUser/IsDevice. Without a
+// role claim, the extractor falls back to the legacy mender.user /
+// mender.device boolean claims.
+func (b *ExtractorBuilder) WithRoleClaim(name string, fn RoleClaimFunc) *ExtractorBuilder {
+	b.roleClaim = name
+	b.roleFunc = fn
+	return b
+}
+
+// Build returns a reusable Extractor from the builder's configuration.
+func (b *ExtractorBuilder) Build() *Extractor {
+	return &Extractor{
+		subjectClaims: b.subjectClaims,
+		tenantClaims:  b.tenantClaims,
+		planClaims:    b.planClaims,
+		roleClaim:     b.roleClaim,
+		roleFunc:      b.roleFunc,
+	}
+}
+
+// Extractor decodes JWTs into an Identity using the claim names it was
+// built with. It performs no signature verification, exactly like
+// ExtractIdentity; use a Verifier when the token's origin is not
+// otherwise trusted.
+type Extractor struct {
+	subjectClaims []string
+	tenantClaims  []string
+	planClaims    []string
+
+	roleClaim string
+	roleFunc  RoleClaimFunc
+}
+
+// Extract decodes token and maps its claims onto an Identity according
+// to the Extractor's configuration.
+func (e *Extractor) Extract(token string) (Identity, error) {
+	claims, err := decodeClaims(token)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return e.MapIdentity(claims)
+}
+
+// MapIdentity implements ClaimMapper.
+func (e *Extractor) MapIdentity(claims map[string]interface{}) (Identity, error) {
+	sub, err := firstStringClaim(claims, e.subjectClaims)
+	if err != nil {
+		return Identity{}, err
+	}
+	if sub == "" {
+		return Identity{}, errors.Errorf("subject claim not found")
+	}
+
+	tenant, err := firstStringClaim(claims, e.tenantClaims)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	plan, err := firstStringClaim(claims, e.planClaims)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{Subject: sub, Tenant: tenant, Plan: plan}
+	populateExtendedClaims(&identity, claims)
+
+	if e.roleClaim != "" {
+		if val, ok := getNestedClaim(claims, e.roleClaim); ok {
+			if e.roleFunc != nil {
+				identity.IsUser, identity.IsDevice = e.roleFunc(val)
+			}
+			if roles, ok := val.([]interface{}); ok {
+				if names := stringSlice(roles); len(names) > 0 {
+					identity.Roles = names
+				}
+			}
+		}
+	} else {
+		if isUser, err := getBoolClaim(claims, userClaim); err == nil {
+			identity.IsUser = isUser
+		}
+		if isDevice, err := getBoolClaim(claims, deviceClaim); err == nil {
+			identity.IsDevice = isDevice
+		}
+	}
+
+	return identity, nil
+}
+
+// firstStringClaim tries each of names in order and returns the first
+// one present as a string. It mirrors getStringClaim's semantics (a
+// missing claim is not an error) when names has a single element.
+func firstStringClaim(claims rawClaims, names []string) (string, error) {
+	for _, name := range names {
+		raw, ok := claims[name]
+		if !ok {
+			continue
+		}
+		s, ok := raw.(string)
+		if !ok {
+			return "", errors.Errorf("invalid %s format", name)
+		}
+		return s, nil
+	}
+	return "", nil
+}
+
+// getNestedClaim resolves path against claims. A literal key match
+// (including one containing dots, e.g. "mender.tenant") is preferred;
+// failing that, dotted segments are resolved by descending into nested
+// objects, so "realm_access.roles" finds claims["realm_access"]["roles"].
+func getNestedClaim(claims rawClaims, path string) (interface{}, bool) {
+	if v, ok := claims[path]; ok {
+		return v, true
+	}
+
+	head, rest, found := strings.Cut(path, ".")
+	if !found {
+		return nil, false
+	}
+
+	nested, ok := claims[head]
+	if !ok {
+		return nil, false
+	}
+
+	nestedClaims, ok := nested.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	return getNestedClaim(nestedClaims, rest)
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package identity
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultExtractorMatchesExtractIdentity(t *testing.T) {
+	rawclaims := makeClaimsFull("foobar", "tenant1", "enterprise", true, false)
+	token := "foo." + rawclaims + ".bar"
+
+	legacy, err := ExtractIdentity(token)
+	assert.NoError(t, err)
+
+	extracted, err := NewExtractor().Build().Extract(token)
+	assert.NoError(t, err)
+
+	assert.Equal(t, legacy, extracted)
+}
+
+func TestExtractorWithSubjectClaimFallback(t *testing.T) {
+	enc := base64.URLEncoding.EncodeToString([]byte(`{"email": "jdoe@example.com"}`))
+	token := "foo." + enc + ".bar"
+
+	ex := NewExtractor().WithSubjectClaim("sub", "preferred_username", "email").Build()
+	idata, err := ex.Extract(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "jdoe@example.com", idata.Subject)
+}
+
+func TestExtractorWithRoleClaim(t *testing.T) {
+	enc := base64.URLEncoding.EncodeToString(
+		[]byte(`{"sub": "123", "realm_access": {"roles": ["admin", "device"]}}`))
+	token := "foo." + enc + ".bar"
+
+	ex := NewKeycloakExtractor()
+	idata, err := ex.Extract(token)
+	assert.NoError(t, err)
+	assert.True(t, idata.IsUser)
+	assert.True(t, idata.IsDevice)
+}
+
+func TestAuth0Extractor(t *testing.T) {
+	enc := base64.URLEncoding.EncodeToString(
+		[]byte(`{"sub": "123", "https://example.com/roles": ["viewer"]}`))
+	token := "foo." + enc + ".bar"
+
+	ex := NewAuth0Extractor("https://example.com")
+	idata, err := ex.Extract(token)
+	assert.NoError(t, err)
+	assert.True(t, idata.IsUser)
+	assert.False(t, idata.IsDevice)
+}
+
+func TestOIDCExtractorPrefersSub(t *testing.T) {
+	enc := base64.URLEncoding.EncodeToString(
+		[]byte(`{"sub": "123", "preferred_username": "jdoe"}`))
+	token := "foo." + enc + ".bar"
+
+	ex := NewOIDCExtractor()
+	idata, err := ex.Extract(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "123", idata.Subject)
+}
+
+func TestGetNestedClaim(t *testing.T) {
+	claims := rawClaims{
+		"mender.tenant": "flat-value",
+		"realm_access": map[string]interface{}{
+			"roles": []interface{}{"admin"},
+		},
+	}
+
+	v, ok := getNestedClaim(claims, "mender.tenant")
+	assert.True(t, ok)
+	assert.Equal(t, "flat-value", v)
+
+	v, ok = getNestedClaim(claims, "realm_access.roles")
+	assert.True(t, ok)
+	assert.Equal(t, []interface{}{"admin"}, v)
+
+	_, ok = getNestedClaim(claims, "missing.path")
+	assert.False(t, ok)
+}
@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -37,6 +38,46 @@ type Identity struct {
 	IsUser   bool
 	IsDevice bool
 	Plan     string
+
+	// Scopes holds the token's OAuth2 scopes, read from the
+	// space-delimited "scope" claim or, failing that, the "scp" array
+	// claim.
+	Scopes []string
+	// Roles holds role names read from the "roles" claim, Keycloak's
+	// nested "realm_access.roles" claim, or from whatever claim an
+	// Extractor was configured to treat as the role claim.
+	Roles []string
+	// Groups holds group membership read from the "groups" claim.
+	Groups []string
+	// Audience holds the token's "aud" claim.
+	Audience []string
+	// ExpiresAt and IssuedAt are the token's "exp"/"iat" claims. They
+	// are the zero time.Time when the claim is absent.
+	ExpiresAt time.Time
+	IssuedAt  time.Time
+	// Raw holds the token's full claim set, for authorization logic
+	// that needs claims beyond what Identity exposes directly.
+	Raw map[string]interface{}
+}
+
+// HasScope reports whether s is present in identity.Scopes.
+func (identity Identity) HasScope(s string) bool {
+	for _, scope := range identity.Scopes {
+		if scope == s {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether r is present in identity.Roles.
+func (identity Identity) HasRole(r string) bool {
+	for _, role := range identity.Roles {
+		if role == r {
+			return true
+		}
+	}
+	return false
 }
 
 type rawClaims map[string]interface{}
@@ -68,15 +109,27 @@ func decodeClaims(token string) (rawClaims, error) {
 	return claims, nil
 }
 
-// Generate identity information from given JWT by extracting subject and tenant claims.
-// Note that this function does not perform any form of token signature
-// verification.
+// Generate identity information from given JWT by extracting subject and
+// tenant claims.
+//
+// WARNING: this function does not perform any form of token signature
+// verification, it merely trusts the claims encoded in the token. Use
+// Verifier.Extract instead wherever the token's origin is not otherwise
+// guaranteed (e.g. it crosses a trust boundary).
 func ExtractIdentity(token string) (Identity, error) {
 	claims, err := decodeClaims(token)
 	if err != nil {
 		return Identity{}, err
 	}
 
+	return identityFromClaims(claims)
+}
+
+// identityFromClaims builds an Identity from already-decoded claims. It
+// is shared by ExtractIdentity and Verifier.Extract so that both the
+// unverified and the signature-verified paths agree on how claims map
+// onto an Identity.
+func identityFromClaims(claims rawClaims) (Identity, error) {
 	sub, err := getStringClaim(claims, subjectClaim)
 	if err != nil {
 		return Identity{}, err
@@ -104,6 +157,8 @@ func ExtractIdentity(token string) (Identity, error) {
 		identity.IsDevice = isDevice
 	}
 
+	populateExtendedClaims(&identity, claims)
+
 	return identity, nil
 }
 
@@ -73,7 +73,10 @@ func TestExtractIdentity(t *testing.T) {
 	// correct case
 	idata, err := ExtractIdentity("foo." + rawclaims + ".bar")
 	assert.NoError(t, err)
-	assert.Equal(t, Identity{Subject: "foobar"}, idata)
+	assert.Equal(t, Identity{
+		Subject: "foobar",
+		Raw:     map[string]interface{}{"sub": "foobar"},
+	}, idata)
 
 	// missing subject
 	enc := base64.URLEncoding.EncodeToString([]byte(`{"iss": "Mender"}`))
@@ -88,17 +91,31 @@ func TestExtractIdentity(t *testing.T) {
 	enc = base64.URLEncoding.EncodeToString([]byte(`{"sub": "123", "mender.device": true}`))
 	idata, err = ExtractIdentity("foo." + enc + ".bar")
 	assert.NoError(t, err)
-	assert.Equal(t, Identity{Subject: "123", IsDevice: true}, idata)
+	assert.Equal(t, Identity{
+		Subject:  "123",
+		IsDevice: true,
+		Raw:      map[string]interface{}{"sub": "123", "mender.device": true},
+	}, idata)
 
 	enc = base64.URLEncoding.EncodeToString([]byte(`{"sub": "123", "mender.user": true}`))
 	idata, err = ExtractIdentity("foo." + enc + ".bar")
 	assert.NoError(t, err)
-	assert.Equal(t, Identity{Subject: "123", IsUser: true}, idata)
+	assert.Equal(t, Identity{
+		Subject: "123",
+		IsUser:  true,
+		Raw:     map[string]interface{}{"sub": "123", "mender.user": true},
+	}, idata)
 
 	enc = base64.URLEncoding.EncodeToString([]byte(`{"sub": "123", "mender.user": {"garbage": 2}}`))
 	idata, err = ExtractIdentity("foo." + enc + ".bar")
 	assert.NoError(t, err)
-	assert.Equal(t, Identity{Subject: "123"}, idata)
+	assert.Equal(t, Identity{
+		Subject: "123",
+		Raw: map[string]interface{}{
+			"sub":         "123",
+			"mender.user": map[string]interface{}{"garbage": float64(2)},
+		},
+	}, idata)
 
 }
 
@@ -120,7 +137,10 @@ func TestExtractIdentityFromHeaders(t *testing.T) {
 	h.Set("Authorization", "Bearer foo."+rawclaims+".bar")
 	idata, err := ExtractIdentityFromHeaders(h)
 	assert.NoError(t, err)
-	assert.Equal(t, Identity{Subject: "foobar"}, idata)
+	assert.Equal(t, Identity{
+		Subject: "foobar",
+		Raw:     map[string]interface{}{"sub": "foobar"},
+	}, idata)
 }
 
 func TestDecodeClaims(t *testing.T) {
@@ -0,0 +1,357 @@
+// Copyright 2020 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package identity
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	"github.com/pkg/errors"
+)
+
+// default TTL applied to a JWKS cache entry when WithJWKSURL or
+// WithOIDCDiscovery is given a ttl of zero or less.
+const defaultJWKSCacheTTL = 5 * time.Minute
+
+// default timeout applied to the HTTP client used to fetch JWKS
+// documents and OIDC discovery documents, so that a slow or
+// unresponsive endpoint cannot block callers indefinitely.
+const defaultJWKSFetchTimeout = 10 * time.Second
+
+// KeySource resolves the key material used to verify a token's signature.
+// Implementations are expected to cache and refresh keys on their own
+// terms; VerifierOption constructors below wire up the built-in sources.
+type KeySource interface {
+	// Key returns the verification key for the given key ID. An empty
+	// kid is passed when the token header omits one, in which case
+	// sources with a single key should return it unconditionally.
+	Key(ctx context.Context, kid string) (interface{}, error)
+}
+
+// VerifierOption configures a Verifier constructed with NewVerifier.
+type VerifierOption func(*Verifier) error
+
+// Verifier validates the signature and standard claims of a JWT before
+// handing back an Identity. Unlike ExtractIdentity, tokens accepted by a
+// Verifier are guaranteed to have been signed by a trusted key and to
+// satisfy the exp/nbf/iat/iss/aud checks configured on it.
+type Verifier struct {
+	keys KeySource
+
+	issuer   string
+	audience string
+
+	leeway time.Duration
+}
+
+// NewVerifier builds a Verifier from the given options. At least one key
+// source option (WithJWKSURL, WithOIDCDiscovery, WithStaticRSAKey or
+// WithHMACSecret) must be supplied.
+func NewVerifier(ctx context.Context, opts ...VerifierOption) (*Verifier, error) {
+	v := &Verifier{
+		leeway: time.Minute,
+	}
+
+	for _, opt := range opts {
+		if err := opt(v); err != nil {
+			return nil, err
+		}
+	}
+
+	if v.keys == nil {
+		return nil, errors.New("identity: no key source configured")
+	}
+
+	return v, nil
+}
+
+// WithIssuer requires the token's `iss` claim to equal issuer.
+func WithIssuer(issuer string) VerifierOption {
+	return func(v *Verifier) error {
+		v.issuer = issuer
+		return nil
+	}
+}
+
+// WithAudience requires the token's `aud` claim to contain audience.
+func WithAudience(audience string) VerifierOption {
+	return func(v *Verifier) error {
+		v.audience = audience
+		return nil
+	}
+}
+
+// WithLeeway overrides the default one minute clock-skew leeway applied
+// to exp/nbf/iat checks.
+func WithLeeway(d time.Duration) VerifierOption {
+	return func(v *Verifier) error {
+		v.leeway = d
+		return nil
+	}
+}
+
+// WithStaticRSAKey verifies tokens with a single well-known RSA public
+// key, typically used for RS256.
+func WithStaticRSAKey(key *rsa.PublicKey) VerifierOption {
+	return func(v *Verifier) error {
+		v.keys = staticKeySource{key: key}
+		return nil
+	}
+}
+
+// WithHMACSecret verifies tokens with a single shared secret, typically
+// used for HS256.
+func WithHMACSecret(secret []byte) VerifierOption {
+	return func(v *Verifier) error {
+		v.keys = staticKeySource{key: secret}
+		return nil
+	}
+}
+
+// WithJWKSURL fetches verification keys from a JWKS endpoint, refreshing
+// the key set once ttl has elapsed, or sooner whenever a token names a
+// kid the cached set does not contain. A ttl of zero uses
+// defaultJWKSCacheTTL.
+func WithJWKSURL(jwksURL string, ttl time.Duration) VerifierOption {
+	return func(v *Verifier) error {
+		if ttl <= 0 {
+			ttl = defaultJWKSCacheTTL
+		}
+		v.keys = &jwksKeySource{
+			jwksURL: jwksURL,
+			ttl:     ttl,
+			client:  &http.Client{Timeout: defaultJWKSFetchTimeout},
+		}
+		return nil
+	}
+}
+
+// WithOIDCDiscovery resolves `jwks_uri` from the OIDC discovery document
+// at issuerURL + "/.well-known/openid-configuration" and refreshes the
+// resulting key set as WithJWKSURL does (defaultJWKSCacheTTL if ttl is
+// zero).
+func WithOIDCDiscovery(ctx context.Context, issuerURL string, ttl time.Duration) VerifierOption {
+	return func(v *Verifier) error {
+		jwksURL, err := discoverJWKSURI(ctx, &http.Client{Timeout: defaultJWKSFetchTimeout}, issuerURL)
+		if err != nil {
+			return errors.Wrap(err, "identity: OIDC discovery failed")
+		}
+		return WithJWKSURL(jwksURL, ttl)(v)
+	}
+}
+
+type oidcDiscoveryDoc struct {
+	JWKSURI string `json:"jwks_uri"`
+}
+
+func discoverJWKSURI(ctx context.Context, client *http.Client, issuerURL string) (string, error) {
+	wellKnown := strings.TrimRight(issuerURL, "/") + "/.well-known/openid-configuration"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return "", err
+	}
+
+	rsp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("unexpected status %v fetching %v", rsp.StatusCode, wellKnown)
+	}
+
+	var doc oidcDiscoveryDoc
+	if err := json.NewDecoder(rsp.Body).Decode(&doc); err != nil {
+		return "", errors.Wrap(err, "failed to decode discovery document")
+	}
+	if doc.JWKSURI == "" {
+		return "", errors.Errorf("discovery document %v is missing jwks_uri", wellKnown)
+	}
+
+	return doc.JWKSURI, nil
+}
+
+// staticKeySource always returns the same key regardless of kid.
+type staticKeySource struct {
+	key interface{}
+}
+
+func (s staticKeySource) Key(_ context.Context, _ string) (interface{}, error) {
+	return s.key, nil
+}
+
+// jwksKeySource fetches and caches a JWKS document, re-fetching it once
+// ttl has elapsed or when an unknown kid is requested.
+type jwksKeySource struct {
+	jwksURL string
+	ttl     time.Duration
+	client  *http.Client
+
+	mu      sync.Mutex
+	fetched time.Time
+	keySet  jose.JSONWebKeySet
+}
+
+// Key returns the key for kid, fetching (or re-fetching) the JWKS
+// document if the cache is stale or doesn't contain kid. The network
+// fetch itself runs without holding s.mu, so it cannot block other
+// goroutines reading the cached key set - only the brief read/write of
+// the cache is serialized.
+func (s *jwksKeySource) Key(ctx context.Context, kid string) (interface{}, error) {
+	s.mu.Lock()
+	keySet := s.keySet
+	stale := time.Since(s.fetched) > s.ttl || !haveKid(keySet, kid)
+	s.mu.Unlock()
+
+	if stale {
+		fetched, err := s.fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		keySet = fetched
+
+		s.mu.Lock()
+		s.keySet = keySet
+		s.fetched = time.Now()
+		s.mu.Unlock()
+	}
+
+	for _, k := range keySet.Keys {
+		if kid == "" || k.KeyID == kid {
+			return k.Key, nil
+		}
+	}
+
+	return nil, errors.Errorf("identity: no key found for kid %q", kid)
+}
+
+func haveKid(keySet jose.JSONWebKeySet, kid string) bool {
+	if kid == "" {
+		return len(keySet.Keys) > 0
+	}
+	for _, k := range keySet.Keys {
+		if k.KeyID == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// fetch retrieves and decodes the JWKS document, honoring ctx's deadline
+// and the key source's client timeout. It does not mutate s, so callers
+// are free to call it without holding s.mu.
+func (s *jwksKeySource) fetch(ctx context.Context) (jose.JSONWebKeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.jwksURL, nil)
+	if err != nil {
+		return jose.JSONWebKeySet{}, err
+	}
+
+	rsp, err := s.client.Do(req)
+	if err != nil {
+		return jose.JSONWebKeySet{}, errors.Wrap(err, "failed to fetch JWKS")
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return jose.JSONWebKeySet{}, errors.Errorf("unexpected status %v fetching JWKS from %v", rsp.StatusCode, s.jwksURL)
+	}
+
+	var keySet jose.JSONWebKeySet
+	if err := json.NewDecoder(rsp.Body).Decode(&keySet); err != nil {
+		return jose.JSONWebKeySet{}, errors.Wrap(err, "failed to decode JWKS")
+	}
+
+	return keySet, nil
+}
+
+// Extract is equivalent to ExtractContext(context.Background(), token).
+// Prefer ExtractContext so that a slow or unresponsive JWKS endpoint can
+// be bounded by the caller's own deadline/cancellation.
+func (v *Verifier) Extract(token string) (Identity, error) {
+	return v.ExtractContext(context.Background(), token)
+}
+
+// ExtractContext verifies the signature and standard claims of token and
+// returns the resulting Identity. The signature is checked against the
+// Verifier's configured KeySource, selecting the key by the token's
+// `kid` header when present. ctx bounds any network fetch the KeySource
+// needs to perform (e.g. a JWKS refresh).
+func (v *Verifier) ExtractContext(ctx context.Context, token string) (Identity, error) {
+	tok, err := jwt.ParseSigned(token)
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "identity: failed to parse token")
+	}
+
+	var kid string
+	if len(tok.Headers) > 0 {
+		kid = tok.Headers[0].KeyID
+	}
+
+	key, err := v.keys.Key(ctx, kid)
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "identity: failed to resolve verification key")
+	}
+
+	var claims jwt.Claims
+	var raw rawClaims
+	if err := tok.Claims(key, &claims, &raw); err != nil {
+		return Identity{}, errors.Wrap(err, "identity: signature verification failed")
+	}
+
+	expected := jwt.Expected{Time: time.Now()}
+	if v.issuer != "" {
+		expected.Issuer = v.issuer
+	}
+	if v.audience != "" {
+		expected.Audience = jwt.Audience{v.audience}
+	}
+	if err := claims.ValidateWithLeeway(expected, v.leeway); err != nil {
+		return Identity{}, errors.Wrap(err, "identity: claim validation failed")
+	}
+
+	return identityFromClaims(raw)
+}
+
+// ExtractFromHeaders is equivalent to
+// ExtractFromHeadersContext(context.Background(), headers).
+func (v *Verifier) ExtractFromHeaders(headers http.Header) (Identity, error) {
+	return v.ExtractFromHeadersContext(context.Background(), headers)
+}
+
+// ExtractFromHeadersContext is the verified equivalent of
+// ExtractIdentityFromHeaders: it reads the `Bearer` token from the
+// Authorization header and verifies it via ExtractContext.
+func (v *Verifier) ExtractFromHeadersContext(ctx context.Context, headers http.Header) (Identity, error) {
+	auth := strings.Split(headers.Get("Authorization"), " ")
+
+	if len(auth) != 2 {
+		return Identity{}, errors.Errorf("malformed authorization data")
+	}
+
+	if auth[0] != "Bearer" {
+		return Identity{}, errors.Errorf("unknown authorization method %v", auth[0])
+	}
+
+	return v.ExtractContext(ctx, auth[1])
+}
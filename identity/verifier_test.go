@@ -0,0 +1,255 @@
+// Copyright 2020 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signHS256(t *testing.T, secret []byte, claims jwt.Claims, extra map[string]interface{}) string {
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: secret}, nil)
+	require.NoError(t, err)
+
+	builder := jwt.Signed(sig).Claims(claims)
+	if extra != nil {
+		builder = builder.Claims(extra)
+	}
+	token, err := builder.CompactSerialize()
+	require.NoError(t, err)
+	return token
+}
+
+func TestVerifierHMAC(t *testing.T) {
+	secret := []byte("super-secret")
+
+	v, err := NewVerifier(context.Background(), WithHMACSecret(secret))
+	require.NoError(t, err)
+
+	now := time.Now()
+	token := signHS256(t, secret, jwt.Claims{
+		Subject:   "123",
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(time.Hour)),
+	}, nil)
+
+	idata, err := v.Extract(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "123", idata.Subject)
+
+	// tampered signature is rejected
+	_, err = v.Extract(token[:len(token)-2] + "xx")
+	assert.Error(t, err)
+
+	// expired token is rejected
+	expired := signHS256(t, secret, jwt.Claims{
+		Subject: "123",
+		Expiry:  jwt.NewNumericDate(now.Add(-time.Hour)),
+	}, nil)
+	_, err = v.Extract(expired)
+	assert.Error(t, err)
+}
+
+func TestVerifierIssuerAndAudience(t *testing.T) {
+	secret := []byte("super-secret")
+
+	v, err := NewVerifier(context.Background(),
+		WithHMACSecret(secret),
+		WithIssuer("mender"),
+		WithAudience("mender-api"),
+	)
+	require.NoError(t, err)
+
+	now := time.Now()
+	token := signHS256(t, secret, jwt.Claims{
+		Subject:  "123",
+		Issuer:   "mender",
+		Audience: jwt.Audience{"mender-api"},
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+	}, nil)
+
+	_, err = v.Extract(token)
+	assert.NoError(t, err)
+
+	wrongIssuer := signHS256(t, secret, jwt.Claims{
+		Subject:  "123",
+		Issuer:   "someone-else",
+		Audience: jwt.Audience{"mender-api"},
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+	}, nil)
+	_, err = v.Extract(wrongIssuer)
+	assert.Error(t, err)
+}
+
+func TestVerifierJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwk := jose.JSONWebKey{Key: &key.PublicKey, KeyID: "test-key", Algorithm: "RS256", Use: "sig"}
+	keySet := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{jwk}}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(keySet)
+	}))
+	defer srv.Close()
+
+	v, err := NewVerifier(context.Background(), WithJWKSURL(srv.URL, time.Minute))
+	require.NoError(t, err)
+
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithHeader("kid", "test-key"),
+	)
+	require.NoError(t, err)
+
+	now := time.Now()
+	token, err := jwt.Signed(sig).Claims(jwt.Claims{
+		Subject: "device-1",
+		Expiry:  jwt.NewNumericDate(now.Add(time.Hour)),
+	}).CompactSerialize()
+	require.NoError(t, err)
+
+	idata, err := v.Extract(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "device-1", idata.Subject)
+}
+
+func TestVerifierExtractFromHeaders(t *testing.T) {
+	secret := []byte("super-secret")
+	v, err := NewVerifier(context.Background(), WithHMACSecret(secret))
+	require.NoError(t, err)
+
+	now := time.Now()
+	token := signHS256(t, secret, jwt.Claims{
+		Subject: "123",
+		Expiry:  jwt.NewNumericDate(now.Add(time.Hour)),
+	}, nil)
+
+	h := http.Header{}
+	h.Set("Authorization", "Bearer "+token)
+	idata, err := v.ExtractFromHeaders(h)
+	assert.NoError(t, err)
+	assert.Equal(t, "123", idata.Subject)
+
+	h.Set("Authorization", "Basic foobar")
+	_, err = v.ExtractFromHeaders(h)
+	assert.Error(t, err)
+}
+
+func TestNewVerifierRequiresKeySource(t *testing.T) {
+	_, err := NewVerifier(context.Background())
+	assert.Error(t, err)
+}
+
+func TestVerifierJWKSContextBoundsSlowEndpoint(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	v, err := NewVerifier(context.Background(), WithJWKSURL(srv.URL, time.Minute))
+	require.NoError(t, err)
+
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithHeader("kid", "test-key"),
+	)
+	require.NoError(t, err)
+
+	token, err := jwt.Signed(sig).Claims(jwt.Claims{Subject: "device-1"}).CompactSerialize()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := v.ExtractContext(ctx, token)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("ExtractContext did not return promptly when ctx was cancelled")
+	}
+}
+
+func TestJWKSKeySourceConcurrentAccessDuringRefresh(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwk := jose.JSONWebKey{Key: &key.PublicKey, KeyID: "test-key", Algorithm: "RS256", Use: "sig"}
+	keySet := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{jwk}}
+
+	var requests int32
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			<-block
+		}
+		_ = json.NewEncoder(w).Encode(keySet)
+	}))
+	defer srv.Close()
+
+	source := &jwksKeySource{jwksURL: srv.URL, ttl: time.Minute, client: &http.Client{Timeout: 5 * time.Second}}
+
+	// The first call blocks in the handler, holding no lock on source.
+	// A concurrent call for a kid the (still empty) cache lacks must not
+	// be serialized behind the in-flight network fetch.
+	firstDone := make(chan struct{})
+	go func() {
+		defer close(firstDone)
+		_, _ = source.Key(context.Background(), "test-key")
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	unblocked := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		_, err := source.Key(ctx, "unknown-kid")
+		unblocked <- err
+	}()
+
+	select {
+	case <-unblocked:
+	case <-time.After(time.Second):
+		t.Fatal("Key() blocked behind another goroutine's in-flight JWKS fetch")
+	}
+
+	close(block)
+	<-firstDone
+}